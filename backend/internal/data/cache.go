@@ -0,0 +1,156 @@
+package data
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize is the tokenCache capacity used when Models.CacheSize is unset.
+const defaultCacheSize = 10000
+
+// defaultCacheTTL is the tokenCache entry lifetime used when Models.CacheTTL is unset.
+const defaultCacheTTL = 5 * time.Minute
+
+// activeTokenCache mirrors Models.CacheSize/Models.CacheTTL. It is kept as a package-level
+// variable, alongside store and activeRetryPolicy, because Token.AuthenticationToken and friends
+// are *Token methods rather than Models receivers.
+var activeTokenCache = newTokenCache(defaultCacheSize, defaultCacheTTL)
+
+// cacheEntry is the value held by tokenCache, keyed by the hex-encoded SHA-256 hash of the
+// plaintext bearer token.
+type cacheEntry struct {
+	hash      string
+	userID    int
+	user      *User
+	expiresAt time.Time
+}
+
+// tokenCache is an in-process LRU cache of recently-validated token_hash -> *User, so
+// Token.AuthenticationToken can avoid a DB round-trip on every request. Entries are evicted on
+// read after expiresAt, on LRU overflow, and on demand via evictHash/evictUser, which
+// Token.DeleteToken/Token.DeleteAllForUser and Token.StartInvalidationListener call to keep every
+// app instance's cache consistent with the database.
+type tokenCache struct {
+	mu     sync.Mutex
+	size   int
+	ttl    time.Duration
+	ll     *list.List
+	items  map[string]*list.Element
+	byUser map[int]map[string]struct{}
+}
+
+// newTokenCache builds a tokenCache with the given capacity and entry TTL, falling back to
+// defaultCacheSize/defaultCacheTTL for non-positive values.
+func newTokenCache(size int, ttl time.Duration) *tokenCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	return &tokenCache{
+		size:   size,
+		ttl:    ttl,
+		ll:     list.New(),
+		items:  make(map[string]*list.Element),
+		byUser: make(map[int]map[string]struct{}),
+	}
+}
+
+// get returns the cached user for hash, if present and not expired.
+func (c *tokenCache) get(hash string) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[hash]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	return entry.user, true
+}
+
+// set caches user under hash, associated with userID for evictUser, evicting the least-recently
+// used entry if the cache is at capacity.
+func (c *tokenCache) set(hash string, userID int, user *User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[hash]; ok {
+		c.removeElement(el)
+	}
+
+	el := c.ll.PushFront(&cacheEntry{
+		hash:      hash,
+		userID:    userID,
+		user:      user,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+	c.items[hash] = el
+
+	if c.byUser[userID] == nil {
+		c.byUser[userID] = make(map[string]struct{})
+	}
+	c.byUser[userID][hash] = struct{}{}
+
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// evictHash removes the cache entry for a single token hash, if present.
+func (c *tokenCache) evictHash(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[hash]; ok {
+		c.removeElement(el)
+	}
+}
+
+// evictUser removes every cache entry belonging to userID, for "logout everywhere" invalidation.
+func (c *tokenCache) evictUser(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for hash := range c.byUser[userID] {
+		if el, ok := c.items[hash]; ok {
+			c.removeElement(el)
+		}
+	}
+}
+
+// evict interprets payload as delivered over NOTIFY token_invalidation: a bare integer evicts
+// every entry for that user ID (Token.DeleteAllForUser), anything else is treated as a hex token
+// hash and evicts that single entry (Token.DeleteToken).
+func (c *tokenCache) evict(payload string) {
+	if userID, err := strconv.Atoi(payload); err == nil {
+		c.evictUser(userID)
+		return
+	}
+	c.evictHash(payload)
+}
+
+// removeElement unlinks el from both the LRU list and the byUser index. Callers must hold c.mu.
+func (c *tokenCache) removeElement(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	c.ll.Remove(el)
+	delete(c.items, entry.hash)
+
+	if hashes, ok := c.byUser[entry.userID]; ok {
+		delete(hashes, entry.hash)
+		if len(hashes) == 0 {
+			delete(c.byUser, entry.userID)
+		}
+	}
+}
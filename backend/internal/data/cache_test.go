@@ -0,0 +1,134 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenCache_SetGet(t *testing.T) {
+	c := newTokenCache(10, time.Minute)
+	user := &User{ID: 1}
+
+	if _, ok := c.get("missing"); ok {
+		t.Fatal("get() on an empty cache returned ok = true")
+	}
+
+	c.set("hash1", user.ID, user)
+
+	got, ok := c.get("hash1")
+	if !ok {
+		t.Fatal("get() after set() returned ok = false")
+	}
+	if got != user {
+		t.Errorf("get() returned a different *User than was set")
+	}
+}
+
+func TestTokenCache_ExpiresEntries(t *testing.T) {
+	c := newTokenCache(10, time.Millisecond)
+	c.set("hash1", 1, &User{ID: 1})
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := c.get("hash1"); ok {
+		t.Fatal("get() returned ok = true for an entry past its TTL")
+	}
+}
+
+func TestTokenCache_EvictsLeastRecentlyUsedOnOverflow(t *testing.T) {
+	c := newTokenCache(2, time.Minute)
+	c.set("a", 1, &User{ID: 1})
+	c.set("b", 2, &User{ID: 2})
+
+	// Touch "a" so it's most-recently-used, leaving "b" as the eviction candidate.
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("get(\"a\") = false, want true before overflow")
+	}
+
+	c.set("c", 3, &User{ID: 3})
+
+	if _, ok := c.get("b"); ok {
+		t.Error("get(\"b\") = true, want false: least-recently-used entry should have been evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("get(\"a\") = false, want true: recently-used entry should survive overflow")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("get(\"c\") = false, want true: newly-set entry should be present")
+	}
+}
+
+func TestTokenCache_EvictHash(t *testing.T) {
+	c := newTokenCache(10, time.Minute)
+	c.set("hash1", 1, &User{ID: 1})
+
+	c.evictHash("hash1")
+
+	if _, ok := c.get("hash1"); ok {
+		t.Fatal("get() returned ok = true after evictHash()")
+	}
+}
+
+func TestTokenCache_EvictUser(t *testing.T) {
+	c := newTokenCache(10, time.Minute)
+	c.set("hash1", 1, &User{ID: 1})
+	c.set("hash2", 1, &User{ID: 1})
+	c.set("hash3", 2, &User{ID: 2})
+
+	c.evictUser(1)
+
+	if _, ok := c.get("hash1"); ok {
+		t.Error("get(\"hash1\") = true after evictUser(1), want false")
+	}
+	if _, ok := c.get("hash2"); ok {
+		t.Error("get(\"hash2\") = true after evictUser(1), want false")
+	}
+	if _, ok := c.get("hash3"); !ok {
+		t.Error("get(\"hash3\") = false after evictUser(1), want true: belongs to a different user")
+	}
+}
+
+func TestTokenCache_Evict_DispatchesByPayloadShape(t *testing.T) {
+	c := newTokenCache(10, time.Minute)
+	c.set("hash1", 42, &User{ID: 42})
+	c.set("deadbeef", 7, &User{ID: 7})
+
+	// A bare integer payload is a user ID (Token.DeleteAllForUser) — evicts every entry for that user.
+	c.evict("42")
+	if _, ok := c.get("hash1"); ok {
+		t.Error("evict(\"42\") did not evict the entry for user 42")
+	}
+
+	// Anything else is treated as a token hash (Token.DeleteToken) — evicts only that one entry.
+	c.evict("deadbeef")
+	if _, ok := c.get("deadbeef"); ok {
+		t.Error("evict(\"deadbeef\") did not evict the matching hash entry")
+	}
+}
+
+func TestTokenCache_SetOverwritesExistingEntry(t *testing.T) {
+	c := newTokenCache(10, time.Minute)
+	first := &User{ID: 1, Email: "old@example.com"}
+	second := &User{ID: 1, Email: "new@example.com"}
+
+	c.set("hash1", 1, first)
+	c.set("hash1", 1, second)
+
+	got, ok := c.get("hash1")
+	if !ok {
+		t.Fatal("get() = false after overwriting set(), want true")
+	}
+	if got != second {
+		t.Error("get() returned the stale *User after set() was called again for the same hash")
+	}
+}
+
+func TestNewTokenCache_FallsBackToDefaults(t *testing.T) {
+	c := newTokenCache(0, 0)
+	if c.size != defaultCacheSize {
+		t.Errorf("newTokenCache(0, 0).size = %d, want %d", c.size, defaultCacheSize)
+	}
+	if c.ttl != defaultCacheTTL {
+		t.Errorf("newTokenCache(0, 0).ttl = %v, want %v", c.ttl, defaultCacheTTL)
+	}
+}
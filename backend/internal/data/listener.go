@@ -0,0 +1,45 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// StartInvalidationListener issues LISTEN channel against the active Store and evicts
+// activeTokenCache of every payload it receives, so the in-process cache stays consistent with
+// the database across every app instance. Token.DeleteToken and Token.DeleteAllForUser always
+// publish on TokenInvalidationChannel, so channel is normally that constant; a listener on any
+// other channel receives none of their invalidations.
+//
+// It returns an error immediately if the active Store does not implement ListenStore — only the
+// pgx-backed store returned by NewPgxStore supports LISTEN, since it requires holding a dedicated
+// connection open rather than borrowing one from a pool. The listener goroutine runs until ctx is
+// cancelled.
+//
+// Parameters:
+//   - ctx: governs the lifetime of the listener goroutine and the underlying connection.
+//   - channel: the Postgres NOTIFY channel to listen on.
+//
+// Returns:
+//   - An error if the active Store doesn't support LISTEN, or if issuing LISTEN fails.
+func (t *Token) StartInvalidationListener(ctx context.Context, channel string) error {
+	listener, ok := t.db().(ListenStore)
+	if !ok {
+		return errors.New("active store does not support LISTEN")
+	}
+
+	payloads, err := listener.Listen(ctx, channel)
+	if err != nil {
+		log.Printf("failed to start token invalidation listener: %v", err)
+		return err
+	}
+
+	go func() {
+		for payload := range payloads {
+			activeTokenCache.evict(payload)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeListenStore pairs a fakeStore with a Listen implementation, so it satisfies ListenStore for
+// StartInvalidationListener tests the way pgxStore does in production.
+type fakeListenStore struct {
+	fakeStore
+	listen func(ctx context.Context, channel string) (<-chan string, error)
+}
+
+func (f *fakeListenStore) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	return f.listen(ctx, channel)
+}
+
+func TestStartInvalidationListener_RejectsUnsupportedStore(t *testing.T) {
+	tok := &Token{store: &fakeStore{}}
+
+	if err := tok.StartInvalidationListener(context.Background(), "token_invalidation"); err == nil {
+		t.Fatal("StartInvalidationListener() error = nil, want error when the Store doesn't implement ListenStore")
+	}
+}
+
+func TestStartInvalidationListener_PropagatesListenError(t *testing.T) {
+	wantErr := errors.New("listen failed")
+	tok := &Token{store: &fakeListenStore{
+		listen: func(ctx context.Context, channel string) (<-chan string, error) {
+			return nil, wantErr
+		},
+	}}
+
+	if err := tok.StartInvalidationListener(context.Background(), "token_invalidation"); !errors.Is(err, wantErr) {
+		t.Fatalf("StartInvalidationListener() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestStartInvalidationListener_EvictsOnPayload(t *testing.T) {
+	activeTokenCache = newTokenCache(defaultCacheSize, defaultCacheTTL)
+	activeTokenCache.set("deadbeef", 1, &User{ID: 1})
+
+	payloads := make(chan string, 1)
+	tok := &Token{store: &fakeListenStore{
+		listen: func(ctx context.Context, channel string) (<-chan string, error) {
+			return payloads, nil
+		},
+	}}
+
+	if err := tok.StartInvalidationListener(context.Background(), "token_invalidation"); err != nil {
+		t.Fatalf("StartInvalidationListener() error = %v, want nil", err)
+	}
+
+	payloads <- "deadbeef"
+	close(payloads)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := activeTokenCache.get("deadbeef"); !ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("StartInvalidationListener() did not evict the cache entry for the received payload in time")
+}
@@ -6,10 +6,12 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,21 +20,122 @@ import (
 
 const dbTimeout = time.Second * 3
 
-var db *sql.DB
+// Token kinds. TokenKindAuth is a normal bearer token returned after successful authentication.
+// TokenKindPending2FA is issued in place of a bearer token when a user has TOTP enabled and is
+// exchanged for a TokenKindAuth token via Token.Complete2FA.
+const (
+	TokenKindAuth       = "auth"
+	TokenKindPending2FA = "2fa_pending"
+)
+
+// Token scopes. They identify what area of the application a token authorizes, independently of
+// Kind, so that sweeps and lookups can be scoped correctly.
+const (
+	TokenScopeAuth          = "auth"
+	TokenScopePasswordReset = "password-reset"
+	TokenScopeEmailVerify   = "email-verify"
+)
+
+// TokenInvalidationChannel is the Postgres NOTIFY channel used to propagate token-cache
+// invalidations across app instances. See Token.StartInvalidationListener.
+const TokenInvalidationChannel = "token_invalidation"
+
+// pending2FATokenTTL is how long a pending 2FA token remains valid before the user must restart
+// the login flow.
+const pending2FATokenTTL = 5 * time.Minute
 
+// defaultTokenTTL is the lifetime of a full bearer token issued after Complete2FA.
+const defaultTokenTTL = 24 * time.Hour
+
+// ErrTOTPRequired is returned by AuthenticationToken when the presented bearer token is a
+// TokenKindPending2FA token rather than a fully-authenticated one, signalling that the caller
+// must complete the TOTP challenge via Token.Complete2FA before receiving a real bearer token.
+var ErrTOTPRequired = errors.New("2FA required")
+
+// New builds a Models backed by a plain database/sql connection pool. Use NewWithStore instead to
+// run against a pgx-backed Store, or to inject a fake Store in tests.
 func New(dbPool *sql.DB) Models {
-	db = dbPool
+	return NewWithStore(NewSQLStore(dbPool))
+}
+
+// NewWithStore builds a Models backed by an arbitrary Store, e.g. a pgx-backed store from
+// NewPgxStore, or a fake for tests.
+func NewWithStore(s Store) Models {
+	store = s
+	activeRetryPolicy = DefaultRetryPolicy
+	activeHashCost = defaultHashCost
+	activeTokenCache = newTokenCache(defaultCacheSize, defaultCacheTTL)
 
 	return Models{
-		User:  User{},
-		Token: Token{},
+		User:           User{store: s},
+		Token:          Token{store: s},
+		Store:          s,
+		RetryPolicy:    DefaultRetryPolicy,
+		ResetURLFormat: defaultResetURLFormat,
+		HashCost:       defaultHashCost,
+		CacheSize:      defaultCacheSize,
+		CacheTTL:       defaultCacheTTL,
 	}
-
 }
 
 type Models struct {
 	User  User
 	Token Token
+	// Store is the database handle m.User and m.Token run queries against. It is set on m.User and
+	// m.Token by New/NewWithStore/SetStore, so a Models built with a fake Store (e.g. in a test)
+	// stays isolated from any other Models built with a different one. Bare User{}/Token{} values
+	// constructed without going through a Models fall back to the package-level default store.
+	Store Store
+	// RetryPolicy is the exponential-backoff policy applied to every Store query and exec call
+	// made by this package (transactions and Notify are not retried). It defaults to DefaultRetryPolicy; use SetRetryPolicy to change it, since the
+	// package functions below operate on *User/*Token rather than a Models receiver.
+	RetryPolicy RetryPolicy
+	// Mailer sends password-reset (and future transactional) emails. It is nil by default;
+	// callers must set it before calling SendPasswordResetEmail.
+	Mailer Mailer
+	// ResetURLFormat is an fmt.Sprintf format string with a single %s verb for the plaintext
+	// reset token, used by callers to build the URL embedded in password-reset emails, e.g.
+	// "https://app.example.com/reset/%s".
+	ResetURLFormat string
+	// HashCost is the bcrypt cost used to hash new passwords set via CompletePasswordReset.
+	// Defaults to defaultHashCost; use SetHashCost to change it, since User.CompletePasswordReset
+	// is a *User method rather than a Models receiver.
+	HashCost int
+	// CacheSize and CacheTTL configure the in-process LRU cache of recently-validated
+	// token_hash -> *User used by Token.AuthenticationToken. Use SetCache to change them.
+	CacheSize int
+	CacheTTL  time.Duration
+}
+
+// SetStore overrides m.Store and the Store backing m.User/m.Token, without affecting any other
+// Models value built from a different store (e.g. a separate instance under test in parallel).
+// It also updates the package-wide default store used by bare User{}/Token{} values.
+func (m *Models) SetStore(s Store) {
+	m.Store = s
+	m.User.store = s
+	m.Token.store = s
+	store = s
+}
+
+// SetHashCost overrides m.HashCost and the bcrypt cost used by CompletePasswordReset.
+func (m *Models) SetHashCost(cost int) {
+	m.HashCost = cost
+	activeHashCost = cost
+}
+
+// SetRetryPolicy overrides m.RetryPolicy and the policy applied to all database calls made by
+// this package.
+func (m *Models) SetRetryPolicy(policy RetryPolicy) {
+	m.RetryPolicy = policy
+	SetRetryPolicy(policy)
+}
+
+// SetCache overrides m.CacheSize/m.CacheTTL and replaces the token cache used by
+// Token.AuthenticationToken, discarding any entries it currently holds.
+func (m *Models) SetCache(size int, ttl time.Duration) {
+	m.CacheSize = size
+	m.CacheTTL = ttl
+	activeTokenCache = newTokenCache(size, ttl)
 }
 
 // User is a struct that represents a user in the database
@@ -53,6 +156,25 @@ type User struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	// Token is a struct that represents a token for the user
 	Token Token `json:"token"`
+	// TOTPSecret is the AES-256-GCM encrypted, base64-encoded TOTP shared secret for the user,
+	// or empty if the user has not enrolled in TOTP. See EnrollTOTP.
+	TOTPSecret string `json:"-"`
+	// TOTPEnabled indicates whether TOTP-based two-factor authentication is active for the user.
+	TOTPEnabled bool `json:"totp_enabled"`
+	// store is the Store this User's methods run queries against. It is set by NewWithStore (via
+	// Models.User) so that separate Models instances — e.g. a real pool in one test and a fake
+	// Store in another running in parallel — stay isolated from each other. A zero-value User
+	// (constructed directly rather than via Models) falls back to the package-level store; see db.
+	store Store
+}
+
+// db returns u's Store, falling back to the package-wide default set by New/NewWithStore/SetStore
+// if u was constructed without one (e.g. a bare User{} rather than through Models.User).
+func (u *User) db() Store {
+	if u.store != nil {
+		return u.store
+	}
+	return store
 }
 
 // GetAll retrieves all users from the database.
@@ -65,9 +187,17 @@ func (u *User) GetAll() ([]*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := "SELECT id, email, first_name, last_name, password, created_at, updated_at FROM users"
+	query := "SELECT id, email, first_name, last_name, password, created_at, updated_at, totp_secret, totp_enabled FROM users"
 
-	rows, err := db.QueryContext(ctx, query)
+	var rows Rows
+	err := withRetry(ctx, func(ctx context.Context) error {
+		r, err := u.db().QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		rows = r
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +210,7 @@ func (u *User) GetAll() ([]*User, error) {
 	var users []*User
 	for rows.Next() {
 		var user User
-		if err := rows.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		if err := rows.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.TOTPSecret, &user.TOTPEnabled); err != nil {
 			return nil, err
 		}
 		users = append(users, &user)
@@ -103,11 +233,13 @@ func (u *User) GetByEmail(email string) (*User, error) {
 	defer cancel()
 
 	// Select the user with the specified email address
-	query := "SELECT id, email, first_name, last_name, password, created_at, updated_at FROM users WHERE email = $1"
+	query := "SELECT id, email, first_name, last_name, password, created_at, updated_at, totp_secret, totp_enabled FROM users WHERE email = $1"
 
-	row := db.QueryRowContext(ctx, query, email)
 	var user User
-	err := row.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	err := withRetry(ctx, func(ctx context.Context) error {
+		row := u.db().QueryRowContext(ctx, query, email)
+		return row.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.TOTPSecret, &user.TOTPEnabled)
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, fmt.Errorf("no user found with email %s", email)
@@ -134,11 +266,13 @@ func (u *User) GetByID(id int) (*User, error) {
 	defer cancel()
 
 	// Select the user with the specified ID
-	query := "SELECT id, email, first_name, last_name, password, created_at, updated_at FROM users WHERE id = $1"
+	query := "SELECT id, email, first_name, last_name, password, created_at, updated_at, totp_secret, totp_enabled FROM users WHERE id = $1"
 
-	row := db.QueryRowContext(ctx, query, id)
 	var user User
-	err := row.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	err := withRetry(ctx, func(ctx context.Context) error {
+		row := u.db().QueryRowContext(ctx, query, id)
+		return row.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.TOTPSecret, &user.TOTPEnabled)
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.New("user not found")
@@ -166,7 +300,10 @@ func (u *User) Update(user User) error {
 	// Update the user in the database
 	query := "UPDATE users SET email = $1, first_name = $2, last_name = $3, updated_at = $4 WHERE id = $5"
 
-	_, err := db.ExecContext(ctx, query, user.Email, user.FirstName, user.LastName, user.UpdatedAt, user.ID)
+	err := withRetry(ctx, func(ctx context.Context) error {
+		_, err := u.db().ExecContext(ctx, query, user.Email, user.FirstName, user.LastName, user.UpdatedAt, user.ID)
+		return err
+	})
 	if err != nil {
 		log.Printf("failed to update user: %v", err)
 		return err
@@ -190,7 +327,10 @@ func (u *User) Delete(id int) error {
 	// Delete the user from the database
 	query := "DELETE FROM users WHERE id = $1"
 
-	_, err := db.ExecContext(ctx, query, id)
+	err := withRetry(ctx, func(ctx context.Context) error {
+		_, err := u.db().ExecContext(ctx, query, id)
+		return err
+	})
 	if err != nil {
 		log.Printf("failed to delete user: %v", err)
 		return err
@@ -220,38 +360,34 @@ func (u *User) Insert(user User) (int, error) {
 	var newID int
 	query := "INSERT INTO users (email, first_name, last_name, password, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6) returning id"
 
-	retryCount := 3
-	for retries := 0; retries < retryCount; retries++ {
-		err = db.QueryRowContext(ctx, query, user.Email, user.FirstName, user.LastName, hashedPassword, user.CreatedAt, user.UpdatedAt).Scan(&newID)
-		if err == nil {
-			user.ID = newID
-			return 0, nil
-		}
-		log.Printf("failed to insert user, attempt %d: %v", retries+1, err)
-		if retries < retryCount-1 {
-			time.Sleep(time.Second * 2)
-		}
+	err = withRetry(ctx, func(ctx context.Context) error {
+		return u.db().QueryRowContext(ctx, query, user.Email, user.FirstName, user.LastName, hashedPassword, user.CreatedAt, user.UpdatedAt).Scan(&newID)
+	})
+	if err != nil {
+		log.Printf("failed to insert user: %v", err)
+		return 0, err
 	}
-	return 0, fmt.Errorf("failed to insert user after %d attempts: %w", retryCount, err)
+
+	user.ID = newID
+	return newID, nil
 }
 
-// ResetPassword resets the password for the user with the specified email address.
-// It returns an error if the user does not exist or if there is an error resetting the password.
+// resetPassword sets u's password to the given bcrypt hash within tx. It is the transactional
+// primitive behind CompletePasswordReset, which commits the password update and the deletion of
+// the spent reset token together so a reset can never be replayed.
 //
 // Parameters:
-//   - email: The email address of the user to reset the password for.
+//   - ctx: the context governing tx.
+//   - tx: the transaction to execute within.
+//   - hashedPassword: the bcrypt hash to store.
 //
 // Returns:
-//   - An error if the user does not exist or if there is an error resetting the password.
-func (u *User) ResetPassword(password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	// Select the user with the specified password address
-	query := "update users set password = $1 where id = $2"
-	_, err := db.ExecContext(ctx, query, password, u.ID)
-	if err != nil {
-		log.Printf("failed to update user: %v", err)
+//   - An error if the update fails.
+func (u *User) resetPassword(ctx context.Context, tx Tx, hashedPassword []byte) error {
+	query := "UPDATE users SET password = $1, updated_at = $2 WHERE id = $3"
+	if _, err := tx.ExecContext(ctx, query, hashedPassword, time.Now(), u.ID); err != nil {
+		log.Printf("failed to reset password for user %d: %v", u.ID, err)
+		return err
 	}
 
 	return nil
@@ -286,80 +422,73 @@ type Token struct {
 	UserID int `json:"user_id"`
 	// Email is the email address of the user associated with the token
 	Email string `json:"email"`
-	// Token is the token value
-	Token string `json:"token"`
-	// TokenHash is the hashed token value
+	// Token is the plaintext token value. It is never persisted — only TokenHash is stored in the
+	// database — and is populated solely so the caller who generated or looked up the token has
+	// it once, e.g. to embed in a bearer header.
+	Token string `json:"token,omitempty"`
+	// TokenHash is the SHA-256 hash of Token. This is the only form of the token stored in or
+	// looked up from the database.
 	TokenHash []byte `json:"-"`
+	// Kind identifies what the token may be used for, e.g. TokenKindAuth or TokenKindPending2FA.
+	Kind string `json:"kind"`
+	// Scope identifies what area of the application the token authorizes, e.g. TokenScopeAuth,
+	// TokenScopePasswordReset, or TokenScopeEmailVerify. It lets Sweep and lookups reason about a
+	// token's purpose independently of its Kind.
+	Scope string `json:"scope"`
 	// CreatedAt is the timestamp when the token was created
 	CreatedAt time.Time `json:"created_at"`
 	// UpdatedAt is the timestamp when the token was last updated
 	UpdatedAt time.Time `json:"updated_at"`
 	// Expiry is the expiry time of the token
 	Expiry time.Time `json:"expiry"`
+	// store is the Store this Token's methods run queries against; see User.store/User.db.
+	store Store
+}
+
+// db returns t's Store, falling back to the package-wide default set by New/NewWithStore/SetStore
+// if t was constructed without one (e.g. a bare Token{} rather than through Models.Token).
+func (t *Token) db() Store {
+	if t.store != nil {
+		return t.store
+	}
+	return store
 }
 
-// GetByToken retrieves a token from the database by its token value.
+// GetByToken retrieves a token from the database by hashing plainText and looking it up by
+// token_hash; the database never stores or is queried by the plaintext value itself.
 // It returns a pointer to the Token struct if the token is found, or nil if not found.
 //
 // Parameters:
-//   - token: The token value to retrieve.
+//   - plainText: The plaintext token value to look up.
 //
 // Returns:
-//   - A pointer to the Token struct representing the token with the specified token value.
+//   - A pointer to the Token struct representing the token with the specified value.
 //   - An error if any occurs during the query execution or row scanning.
 func (t *Token) GetByToken(plainText string) (*Token, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	// Select the plainText with the specified plainText value
-	query := "SELECT id, user_id, email, plainText, token_hash, created_at, updated_at, expiry FROM tokens WHERE plainText = $1"
+	hash := sha256.Sum256([]byte(plainText))
+	query := "SELECT id, user_id, email, token_hash, kind, scope, created_at, updated_at, expiry FROM tokens WHERE token_hash = $1"
 
 	var token Token
-	row := db.QueryRowContext(ctx, query, plainText)
-	err := row.Scan(&token.ID, &token.UserID, &token.Email, &token.Token, &token.TokenHash, &token.CreatedAt, &token.UpdatedAt, &token.Expiry)
+	err := withRetry(ctx, func(ctx context.Context) error {
+		row := t.db().QueryRowContext(ctx, query, hash[:])
+		return row.Scan(&token.ID, &token.UserID, &token.Email, &token.TokenHash, &token.Kind, &token.Scope, &token.CreatedAt, &token.UpdatedAt, &token.Expiry)
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("no plainText found with plainText %s", plainText)
+			return nil, errors.New("no token found matching the supplied value")
 
 		}
-		log.Printf("failed to get plainText by plainText: %v", err)
+		log.Printf("failed to get token by hash: %v", err)
 		return nil, err
 	}
 
+	token.Token = plainText
 	return &token, nil
 }
 
-// GetUserByToken retrieves the user associated with a token from the database.
-// It returns a pointer to the User struct if the user is found, or nil if not found.
-//
-// Parameters:
-//   - token: The token value to retrieve.
-//
-// Returns:
-//   - A pointer to the User struct representing the user associated with the token.
-//   - An error if any occurs during the query execution or row scanning.
-func (t *Token) GetUserByToken(token Token) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
-	// Select the user associated with the token
-	query := "SELECT id, email, first_name, last_name, password, created_at, updated_at FROM users WHERE id = $1"
-
-	var user User
-	row := db.QueryRowContext(ctx, query, token.UserID)
-	err := row.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("no user found with token %v", token)
-
-		}
-		log.Printf("failed to get user by token (token user ID: %v): %v", token.UserID, err)
-		return nil, err
-	}
-
-	return &user, nil
-}
-
 // GenerateToken generates a token for a user.
 // It returns a pointer to the Token struct representing the generated token.
 //
@@ -375,7 +504,7 @@ func (t *Token) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
 		Expiry: time.Now().Add(ttl),
 	}
 
-	randomBytes := make([]byte, 16)
+	randomBytes := make([]byte, 32)
 	_, err := rand.Read(randomBytes)
 	if err != nil {
 		log.Printf("failed to generate random bytes: %v", err)
@@ -385,10 +514,31 @@ func (t *Token) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
 	token.Token = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 	hash := sha256.Sum256([]byte(token.Token))
 	token.TokenHash = hash[:]
+	token.Kind = TokenKindAuth
+	token.Scope = TokenScopeAuth
 
 	return token, nil
 }
 
+// GeneratePendingToken generates a short-lived TokenKindPending2FA token for a user who has
+// passed password authentication but still owes a TOTP (or recovery) code. It is exchanged for a
+// full bearer token via Token.Complete2FA.
+//
+// Parameters:
+//   - userID: the ID of the user completing a 2FA challenge.
+//
+// Returns:
+//   - A pointer to the generated pending Token.
+//   - An error if any occurs during the token generation process.
+func (t *Token) GeneratePendingToken(userID int) (*Token, error) {
+	token, err := t.GenerateToken(userID, pending2FATokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	token.Kind = TokenKindPending2FA
+	return token, nil
+}
+
 // AuthenticationToken takes a pointer to http.Request and returns a pointer to User and an error.
 // It returns a pointer to the User struct if the user is found, or nil if not found.
 //
@@ -411,11 +561,18 @@ func (t *Token) AuthenticationToken(r *http.Request) (*User, error) {
 
 	tk := headerParts[1]
 
-	if len(tk) != 26 {
-		return nil, errors.New("invalid token length")
+	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(tk)
+	if err != nil || len(decoded) != 32 {
+		return nil, errors.New("invalid token format")
+	}
+
+	hash := sha256.Sum256([]byte(tk))
+	cacheKey := hex.EncodeToString(hash[:])
+	if user, ok := activeTokenCache.get(cacheKey); ok {
+		return user, nil
 	}
 
-	tokenModel, err := t.GetByToken(tk)
+	tokenModel, user, err := t.GetUserByPlaintext(tk)
 	if err != nil {
 		return nil, errors.New("no matching token found")
 	}
@@ -424,12 +581,77 @@ func (t *Token) AuthenticationToken(r *http.Request) (*User, error) {
 		return nil, errors.New("token expired")
 	}
 
-	user, err := t.GetUserByToken(*tokenModel)
+	if tokenModel.Kind == TokenKindPending2FA {
+		return nil, ErrTOTPRequired
+	}
+
+	if tokenModel.Kind != TokenKindAuth || tokenModel.Scope != TokenScopeAuth {
+		return nil, errors.New("token is not a valid authentication token")
+	}
+
+	activeTokenCache.set(cacheKey, user.ID, user)
+	return user, nil
+}
+
+// Complete2FA exchanges a pending 2FA token and a TOTP (or recovery) code for a full bearer
+// token. Callers reach this after AuthenticationToken has rejected a pending token with
+// ErrTOTPRequired.
+//
+// Parameters:
+//   - pendingToken: the plaintext pending token issued alongside the "2FA required" response.
+//   - code: the 6-digit TOTP code, or a recovery code if the user has lost their device.
+//
+// Returns:
+//   - A pointer to the newly issued Token, valid for normal authentication.
+//   - An error if the pending token or code is invalid or expired.
+func (t *Token) Complete2FA(pendingToken, code string) (*Token, error) {
+	pending, err := t.GetByToken(pendingToken)
+	if err != nil {
+		return nil, errors.New("no matching pending token found")
+	}
+
+	if pending.Kind != TokenKindPending2FA {
+		return nil, errors.New("token is not a pending 2FA token")
+	}
+
+	if pending.Expiry.Before(time.Now()) {
+		return nil, errors.New("pending token expired")
+	}
+
+	user, err := (&User{store: t.db()}).GetByID(pending.UserID)
 	if err != nil {
 		return nil, errors.New("no matching user found")
 	}
 
-	return user, nil
+	valid, err := user.VerifyTOTP(code, 1)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		valid, err = user.UseRecoveryCode(code)
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			return nil, errors.New("invalid totp or recovery code")
+		}
+	}
+
+	if err := t.DeleteToken(pending.Token); err != nil {
+		log.Printf("failed to delete pending 2FA token: %v", err)
+	}
+
+	full, err := t.GenerateToken(user.ID, defaultTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	full.Email = user.Email
+
+	if err := t.InsertToken(*full); err != nil {
+		return nil, err
+	}
+
+	return full, nil
 }
 
 // InsertToken inserts a new token into the database. It takes a token of
@@ -452,8 +674,11 @@ func (t *Token) InsertToken(token Token) error {
 	}
 
 	// insert the token
-	query := "INSERT INTO tokens (user_id, email, token, token_hash, created_at, updated_at, expiry) VALUES ($1, $2, $3, $4, $5, $6, $7)"
-	_, err = db.ExecContext(ctx, query, token.UserID, token.Email, token.Token, token.TokenHash, time.Now(), time.Now(), token.Expiry)
+	query := "INSERT INTO tokens (user_id, email, token_hash, kind, scope, created_at, updated_at, expiry) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+	err = withRetry(ctx, func(ctx context.Context) error {
+		_, err := t.db().ExecContext(ctx, query, token.UserID, token.Email, token.TokenHash, token.Kind, token.Scope, time.Now(), time.Now(), token.Expiry)
+		return err
+	})
 	if err != nil {
 		log.Printf("failed to insert token: %v", err)
 		return err
@@ -462,10 +687,12 @@ func (t *Token) InsertToken(token Token) error {
 	return nil
 }
 
-// DeleteToken deletes a token from the database. It takes a token of type Token and returns an error if any occurs during the token deletion process.
+// DeleteToken deletes a token from the database by hashing plainText and deleting by token_hash.
+// It evicts the token from activeTokenCache and, if the active Store supports it, publishes the
+// token's hash on TokenInvalidationChannel so every other app instance evicts it too.
 //
 // Parameters:
-//   - token: The Token struct representing the token to delete.
+//   - plainText: The plaintext token value to delete.
 //
 // Returns:
 //   - An error if any occurs during the token deletion process.
@@ -473,72 +700,96 @@ func (t *Token) DeleteToken(plainText string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := "DELETE FROM tokens WHERE plainText = $1"
-	_, err := db.ExecContext(ctx, query, plainText)
+	hash := sha256.Sum256([]byte(plainText))
+	cacheKey := hex.EncodeToString(hash[:])
+
+	query := "DELETE FROM tokens WHERE token_hash = $1"
+	err := withRetry(ctx, func(ctx context.Context) error {
+		_, err := t.db().ExecContext(ctx, query, hash[:])
+		return err
+	})
 	if err != nil {
 		log.Printf("failed to delete token: %v", err)
 		return err
 	}
 
+	activeTokenCache.evictHash(cacheKey)
+	if err := t.db().Notify(ctx, TokenInvalidationChannel, cacheKey); err != nil {
+		log.Printf("failed to publish token invalidation: %v", err)
+	}
+
 	return nil
 }
 
-// GetUserWithToken retrieves the user associated with a token from the database.
-// It returns a pointer to the User struct if the user is found, or nil if not found.
+// DeleteAllForUser deletes every token belonging to the given user. It is used on password
+// change and "logout everywhere" to invalidate all of a user's sessions at once. It evicts every
+// cached token for userID and, if the active Store supports it, publishes userID on
+// TokenInvalidationChannel so every other app instance does the same.
 //
 // Parameters:
-//   - token: The token value to retrieve.
+//   - userID: The ID of the user whose tokens should be deleted.
 //
 // Returns:
-//   - A pointer to the User struct representing the user associated with the token.
-//   - An error if any occurs during the query execution or row scanning.
-func (t *Token) GetUserWithToken(token string) (*User, error) {
+//   - An error if any occurs during the token deletion process.
+func (t *Token) DeleteAllForUser(userID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	// Select the user associated with the token
-	query := "SELECT id, email, first_name, last_name, password, created_at, updated_at FROM users WHERE id = $1"
-
-	var user User
-	row := db.QueryRowContext(ctx, query, token)
-	err := row.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	query := "DELETE FROM tokens WHERE user_id = $1"
+	err := withRetry(ctx, func(ctx context.Context) error {
+		_, err := t.db().ExecContext(ctx, query, userID)
+		return err
+	})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("no user found with token %v", token)
+		log.Printf("failed to delete tokens for user %d: %v", userID, err)
+		return err
+	}
 
-		}
-		log.Printf("failed to get user by token (token user ID: %v): %v", token, err)
-		return nil, err
+	activeTokenCache.evictUser(userID)
+	if err := t.db().Notify(ctx, TokenInvalidationChannel, strconv.Itoa(userID)); err != nil {
+		log.Printf("failed to publish token invalidation: %v", err)
 	}
 
-	return &user, nil
+	return nil
 }
 
-// VaildateToken validates a token and returns a boolean indicating whether the token is valid or not.
+// GetUserByPlaintext retrieves a token and the user it belongs to in a single JOIN lookup by the
+// token's SHA-256 hash, replacing the old two-query GetByToken+GetUserByToken round trip used by
+// AuthenticationToken. It replaces the older GetUserWithToken/VaildateToken, which incorrectly
+// queried the users table by the raw token string as if it were a numeric user ID.
 //
 // Parameters:
-//   - token: The token value to validate.
+//   - plaintext: The plaintext token value to look up.
 //
 // Returns:
-//   - A boolean indicating whether the token is valid or not.
-func (t *Token) VaildateToken(token string) (bool, error) {
+//   - A pointer to the Token struct, populated enough for callers to check Kind/Scope/Expiry.
+//   - A pointer to the User struct representing the user the token belongs to.
+//   - An error if no token matches, or if any occurs during the query execution or row scanning.
+func (t *Token) GetUserByPlaintext(plaintext string) (*Token, *User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	// Select the user associated with the token
-	query := "SELECT id, email, first_name, last_name, password, created_at, updated_at FROM users WHERE id = $1"
+	hash := sha256.Sum256([]byte(plaintext))
+	query := `SELECT tokens.id, tokens.user_id, tokens.kind, tokens.scope, tokens.expiry,
+		users.id, users.email, users.first_name, users.last_name, users.password, users.created_at, users.updated_at, users.totp_secret, users.totp_enabled
+		FROM users JOIN tokens ON tokens.user_id = users.id
+		WHERE tokens.token_hash = $1`
 
+	var token Token
 	var user User
-	row := db.QueryRowContext(ctx, query, token)
-	err := row.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+	err := withRetry(ctx, func(ctx context.Context) error {
+		row := t.db().QueryRowContext(ctx, query, hash[:])
+		return row.Scan(&token.ID, &token.UserID, &token.Kind, &token.Scope, &token.Expiry,
+			&user.ID, &user.Email, &user.FirstName, &user.LastName, &user.Password, &user.CreatedAt, &user.UpdatedAt, &user.TOTPSecret, &user.TOTPEnabled)
+	})
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return false, fmt.Errorf("no user found with token %v", token)
+			return nil, nil, errors.New("no user found matching the supplied token")
 
 		}
-		log.Printf("failed to get user by token (token user ID: %v): %v", token, err)
-		return false, err
+		log.Printf("failed to get user by token hash: %v", err)
+		return nil, nil, err
 	}
 
-	return true, nil
+	return &token, &user, nil
 }
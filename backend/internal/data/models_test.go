@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// rowFunc adapts a plain function to the Row interface, so a test can fill in whatever dest
+// values a particular query's Scan call expects without a full fake-database layer.
+type rowFunc func(dest ...any) error
+
+func (f rowFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
+
+// newBearerRequest builds a GET request carrying plaintext as a Bearer Authorization header.
+func newBearerRequest(plaintext string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+plaintext)
+	return r
+}
+
+// randomTokenPlaintext returns a 32-byte random token encoded the same way GenerateToken does.
+func randomTokenPlaintext(t *testing.T) string {
+	t.Helper()
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+}
+
+// tokenRowScanner builds a fakeStore.queryRowContext that scans tok/user back into the dest
+// slots GetUserByPlaintext's JOIN query expects.
+func tokenRowScanner(tok Token, user User) func(ctx context.Context, query string, args ...any) Row {
+	return func(ctx context.Context, query string, args ...any) Row {
+		return rowFunc(func(dest ...any) error {
+			*dest[0].(*int) = tok.ID
+			*dest[1].(*int) = tok.UserID
+			*dest[2].(*string) = tok.Kind
+			*dest[3].(*string) = tok.Scope
+			*dest[4].(*time.Time) = tok.Expiry
+			*dest[5].(*int) = user.ID
+			*dest[6].(*string) = user.Email
+			*dest[7].(*string) = user.FirstName
+			*dest[8].(*string) = user.LastName
+			*dest[9].(*string) = user.Password
+			*dest[10].(*time.Time) = user.CreatedAt
+			*dest[11].(*time.Time) = user.UpdatedAt
+			*dest[12].(*string) = user.TOTPSecret
+			*dest[13].(*bool) = user.TOTPEnabled
+			return nil
+		})
+	}
+}
+
+func TestToken_AuthenticationToken_AcceptsAuthScopedToken(t *testing.T) {
+	activeTokenCache = newTokenCache(defaultCacheSize, defaultCacheTTL)
+
+	plaintext := randomTokenPlaintext(t)
+	wantUser := User{ID: 1, Email: "user@example.com"}
+	tok := &Token{store: &fakeStore{
+		queryRowContext: tokenRowScanner(Token{Kind: TokenKindAuth, Scope: TokenScopeAuth, Expiry: time.Now().Add(time.Hour)}, wantUser),
+	}}
+
+	user, err := tok.AuthenticationToken(newBearerRequest(plaintext))
+	if err != nil {
+		t.Fatalf("AuthenticationToken() error = %v, want nil", err)
+	}
+	if user.ID != wantUser.ID {
+		t.Errorf("AuthenticationToken() user.ID = %d, want %d", user.ID, wantUser.ID)
+	}
+}
+
+func TestToken_AuthenticationToken_RejectsPasswordResetScopedToken(t *testing.T) {
+	activeTokenCache = newTokenCache(defaultCacheSize, defaultCacheTTL)
+
+	plaintext := randomTokenPlaintext(t)
+	tok := &Token{store: &fakeStore{
+		queryRowContext: tokenRowScanner(Token{Kind: "", Scope: TokenScopePasswordReset, Expiry: time.Now().Add(time.Hour)}, User{ID: 1}),
+	}}
+
+	if _, err := tok.AuthenticationToken(newBearerRequest(plaintext)); err == nil {
+		t.Fatal("AuthenticationToken() error = nil, want rejection of a password-reset-scoped token")
+	}
+}
+
+func TestToken_AuthenticationToken_RequiresPending2FA(t *testing.T) {
+	activeTokenCache = newTokenCache(defaultCacheSize, defaultCacheTTL)
+
+	plaintext := randomTokenPlaintext(t)
+	tok := &Token{store: &fakeStore{
+		queryRowContext: tokenRowScanner(Token{Kind: TokenKindPending2FA, Scope: TokenScopeAuth, Expiry: time.Now().Add(time.Hour)}, User{ID: 1}),
+	}}
+
+	_, err := tok.AuthenticationToken(newBearerRequest(plaintext))
+	if err != ErrTOTPRequired {
+		t.Fatalf("AuthenticationToken() error = %v, want %v", err, ErrTOTPRequired)
+	}
+}
+
+func TestToken_AuthenticationToken_RejectsExpiredToken(t *testing.T) {
+	activeTokenCache = newTokenCache(defaultCacheSize, defaultCacheTTL)
+
+	plaintext := randomTokenPlaintext(t)
+	tok := &Token{store: &fakeStore{
+		queryRowContext: tokenRowScanner(Token{Kind: TokenKindAuth, Scope: TokenScopeAuth, Expiry: time.Now().Add(-time.Hour)}, User{ID: 1}),
+	}}
+
+	if _, err := tok.AuthenticationToken(newBearerRequest(plaintext)); err == nil {
+		t.Fatal("AuthenticationToken() error = nil, want rejection of an expired token")
+	}
+}
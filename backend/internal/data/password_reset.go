@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// minResetPasswordLength is the minimum length enforced on a new password submitted to
+// CompletePasswordReset.
+const minResetPasswordLength = 8
+
+// defaultHashCost is the bcrypt cost used by CompletePasswordReset when Models.HashCost is unset.
+const defaultHashCost = 12
+
+// defaultResetURLFormat is the Models.ResetURLFormat used when none is configured.
+const defaultResetURLFormat = "http://app/reset/%s"
+
+// activeHashCost mirrors Models.HashCost. It is kept as a package-level variable, alongside
+// activeRetryPolicy, because CompletePasswordReset is a *User method rather than a Models
+// receiver and has no other way to reach it.
+var activeHashCost = defaultHashCost
+
+// Mailer sends a single plaintext email. Callers plug in an SMTP/SES/etc. implementation via
+// Models.Mailer; Models.SendPasswordResetEmail is the only place in this package that calls it.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SendPasswordResetEmail sends user a password-reset email containing resetURL via m.Mailer.
+//
+// Parameters:
+//   - user: the user the email is addressed to.
+//   - resetURL: the fully-formed reset link, typically fmt.Sprintf(m.ResetURLFormat, plaintext).
+//
+// Returns:
+//   - An error if m.Mailer is nil or sending fails.
+func (m Models) SendPasswordResetEmail(user *User, resetURL string) error {
+	if m.Mailer == nil {
+		return errors.New("no mailer configured")
+	}
+
+	subject := "Reset your password"
+	body := fmt.Sprintf("Hi %s,\n\nUse the link below to reset your password:\n\n%s\n\nIf you didn't request this, you can ignore this email.", user.FirstName, resetURL)
+
+	return m.Mailer.Send(user.Email, subject, body)
+}
+
+// RequestPasswordReset generates a single-use, 32-byte password-reset token for the user with the
+// given email, stores only its SHA-256 hash (scoped to TokenScopePasswordReset) alongside an
+// expiry ttl from now, and returns the plaintext token exactly once so the caller can embed it in
+// a reset URL (see Models.ResetURLFormat) and send it via Models.SendPasswordResetEmail. The
+// plaintext itself is never persisted.
+//
+// Parameters:
+//   - email: the email address of the user requesting a reset.
+//   - ttl: how long the token remains valid.
+//
+// Returns:
+//   - The plaintext reset token.
+//   - An error if the user does not exist or the token could not be stored.
+func (u *User) RequestPasswordReset(email string, ttl time.Duration) (string, error) {
+	user, err := u.GetByEmail(email)
+	if err != nil {
+		return "", err
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		log.Printf("failed to generate password reset token: %v", err)
+		return "", err
+	}
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+	query := "INSERT INTO tokens (user_id, email, token_hash, scope, created_at, updated_at, expiry) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	err = withRetry(ctx, func(ctx context.Context) error {
+		_, err := u.db().ExecContext(ctx, query, user.ID, user.Email, hash[:], TokenScopePasswordReset, now, now, now.Add(ttl))
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to insert password reset token: %v", err)
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// CompletePasswordReset redeems a plaintext password-reset token for newPassword. It looks the
+// token up by the SHA-256 hash of plaintext, comparing the stored and computed hashes in constant
+// time, verifies the token is an unexpired TokenScopePasswordReset token, enforces
+// minResetPasswordLength on newPassword, then bcrypt-hashes it with Models.HashCost and updates
+// the user while deleting the token in a single transaction, so a reset token can never be
+// replayed. Once the password change commits, it also calls Token.DeleteAllForUser to invalidate
+// every bearer token the user already holds, the same "logout everywhere" behaviour a password
+// reset should have — otherwise a session obtained before the reset (e.g. by whoever compromised
+// the account) would stay valid after it.
+//
+// Parameters:
+//   - plaintext: the reset token as emailed to the user.
+//   - newPassword: the new plaintext password to set.
+//
+// Returns:
+//   - An error if the token is missing, expired, or newPassword fails the length policy.
+func (u *User) CompletePasswordReset(plaintext, newPassword string) error {
+	if len(newPassword) < minResetPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minResetPasswordLength)
+	}
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var token Token
+	query := "SELECT id, user_id, token_hash, scope, expiry FROM tokens WHERE token_hash = $1 AND scope = $2"
+	err := withRetry(ctx, func(ctx context.Context) error {
+		row := u.db().QueryRowContext(ctx, query, hash[:], TokenScopePasswordReset)
+		return row.Scan(&token.ID, &token.UserID, &token.TokenHash, &token.Scope, &token.Expiry)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("invalid or expired password reset token")
+		}
+		log.Printf("failed to look up password reset token: %v", err)
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(token.TokenHash, hash[:]) != 1 {
+		return errors.New("invalid or expired password reset token")
+	}
+	if token.Expiry.Before(time.Now()) {
+		return errors.New("invalid or expired password reset token")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), activeHashCost)
+	if err != nil {
+		log.Printf("failed to hash new password: %v", err)
+		return err
+	}
+
+	tx, err := u.db().BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("failed to begin password reset transaction: %v", err)
+		return err
+	}
+
+	resetUser := &User{ID: token.UserID}
+	if err := resetUser.resetPassword(ctx, tx, hashedPassword); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tokens WHERE id = $1", token.ID); err != nil {
+		_ = tx.Rollback()
+		log.Printf("failed to delete password reset token: %v", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit password reset: %v", err)
+		return err
+	}
+
+	if err := (&Token{store: u.db()}).DeleteAllForUser(token.UserID); err != nil {
+		log.Printf("failed to invalidate existing sessions after password reset: %v", err)
+	}
+
+	return nil
+}
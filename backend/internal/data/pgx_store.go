@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgxStore is the pgx-backed Store implementation. Unlike sqlStore, it can hand out a dedicated
+// connection for Token.StartInvalidationListener to LISTEN on, so every app instance in a fleet
+// evicts its own in-process token cache together on NOTIFY token_invalidation.
+type pgxStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewPgxStore wraps an existing *pgxpool.Pool as a Store.
+func NewPgxStore(pool *pgxpool.Pool) Store {
+	return &pgxStore{pool: pool}
+}
+
+func (s *pgxStore) QueryContext(ctx context.Context, query string, args ...any) (Rows, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxRows{rows: rows}, nil
+}
+
+func (s *pgxStore) QueryRowContext(ctx context.Context, query string, args ...any) Row {
+	return s.pool.QueryRow(ctx, query, args...)
+}
+
+func (s *pgxStore) ExecContext(ctx context.Context, query string, args ...any) (Result, error) {
+	tag, err := s.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgxResult{tag: tag}, nil
+}
+
+func (s *pgxStore) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	pgxOpts := pgx.TxOptions{}
+	if opts != nil && opts.ReadOnly {
+		pgxOpts.AccessMode = pgx.ReadOnly
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgxOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxTx{ctx: ctx, tx: tx}, nil
+}
+
+func (s *pgxStore) Notify(ctx context.Context, channel, payload string) error {
+	_, err := s.pool.Exec(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// Listen issues LISTEN channel on a dedicated connection acquired from the pool and streams
+// NOTIFY payloads on the returned channel until ctx is cancelled. It implements ListenStore.
+func (s *pgxStore) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		conn.Release()
+		return nil, err
+	}
+
+	payloads := make(chan string)
+	go func() {
+		defer conn.Release()
+		defer close(payloads)
+
+		for {
+			notification, err := conn.Conn().WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("token invalidation listener stopped: %v", err)
+				}
+				return
+			}
+
+			select {
+			case payloads <- notification.Payload:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return payloads, nil
+}
+
+// pgxRows adapts pgx.Rows to the Rows interface.
+type pgxRows struct {
+	rows pgx.Rows
+}
+
+func (r *pgxRows) Next() bool             { return r.rows.Next() }
+func (r *pgxRows) Scan(dest ...any) error { return r.rows.Scan(dest...) }
+func (r *pgxRows) Err() error             { return r.rows.Err() }
+
+func (r *pgxRows) Close() error {
+	r.rows.Close()
+	return r.rows.Err()
+}
+
+// pgxResult adapts pgconn.CommandTag to the Result interface.
+type pgxResult struct {
+	tag pgconn.CommandTag
+}
+
+func (r pgxResult) RowsAffected() (int64, error) {
+	return r.tag.RowsAffected(), nil
+}
+
+// pgxTx adapts pgx.Tx to the Tx interface. The context a transaction was begun with is reused for
+// Commit/Rollback, matching how *sql.Tx behaves.
+type pgxTx struct {
+	ctx context.Context
+	tx  pgx.Tx
+}
+
+func (t *pgxTx) ExecContext(ctx context.Context, query string, args ...any) (Result, error) {
+	tag, err := t.tx.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgxResult{tag: tag}, nil
+}
+
+func (t *pgxTx) QueryRowContext(ctx context.Context, query string, args ...any) Row {
+	return t.tx.QueryRow(ctx, query, args...)
+}
+
+func (t *pgxTx) Commit() error {
+	return t.tx.Commit(t.ctx)
+}
+
+func (t *pgxTx) Rollback() error {
+	return t.tx.Rollback(t.ctx)
+}
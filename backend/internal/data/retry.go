@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// RetryPolicy configures the exponential-backoff retry behaviour applied to every Store query and
+// exec call made by this package. BeginTx, statements run inside a transaction, Commit and Notify
+// are not retried. The default policy (DefaultRetryPolicy) only retries errors classified as
+// transient, e.g. Postgres/CockroachDB serialization failures and deadlocks.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. A value of 1 disables
+	// retrying.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the computed backoff delay, regardless of attempt number.
+	MaxDelay time.Duration
+	// Multiplier is applied to the delay after each failed attempt.
+	Multiplier float64
+	// Jitter, when true, applies full-jitter (AWS-style) randomization to each computed delay.
+	Jitter bool
+	// Classifier decides whether an error is transient and worth retrying. A nil Classifier
+	// falls back to isTransientError.
+	Classifier func(error) bool
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by Models when none is configured: up to 3 attempts,
+// starting at 100ms and doubling up to 2s, with full jitter.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+	Multiplier:  2,
+	Jitter:      true,
+}
+
+// activeRetryPolicy is the RetryPolicy applied by withRetry. It mirrors Models.RetryPolicy and is
+// kept as a package-level variable alongside store and totpEncryptionKey so that the many package
+// functions operating on *User/*Token (rather than a Models receiver) can all reach it.
+var activeRetryPolicy = DefaultRetryPolicy
+
+// SetRetryPolicy overrides the RetryPolicy used for all database calls made by this package.
+func SetRetryPolicy(policy RetryPolicy) {
+	activeRetryPolicy = policy
+}
+
+// withRetry runs fn against activeRetryPolicy, retrying when fn returns a transient error. It
+// stops early if ctx is cancelled or its deadline is exceeded.
+func withRetry(ctx context.Context, fn func(ctx context.Context) error) error {
+	policy := activeRetryPolicy
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	classify := policy.Classifier
+	if classify == nil {
+		classify = isTransientError
+	}
+
+	var err error
+	delay := policy.BaseDelay
+
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		err = fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if attempt == policy.MaxAttempts || !classify(err) {
+			return err
+		}
+
+		wait := delay
+		if policy.Jitter {
+			wait = fullJitter(delay)
+		}
+		if policy.MaxDelay > 0 && wait > policy.MaxDelay {
+			wait = policy.MaxDelay
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay = time.Duration(float64(delay) * policy.Multiplier)
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+
+	return err
+}
+
+// fullJitter implements the AWS "full jitter" backoff strategy: a uniform random duration in
+// [0, d). It falls back to d itself if a random value cannot be generated.
+func fullJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(d)))
+	if err != nil {
+		return d
+	}
+	return time.Duration(n.Int64())
+}
+
+// isTransientError reports whether err is worth retrying: Postgres/CockroachDB serialization
+// failures and deadlocks (from either the database/sql+lib/pq path or the pgx path), connection-
+// level errors, and context deadline exceeded on the outer call context.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", "40P01": // serialization_failure, deadlock_detected
+			return true
+		}
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", "40P01": // serialization_failure, deadlock_detected
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"deadlock detected", &pq.Error{Code: "40P01"}, true},
+		{"other pq error", &pq.Error{Code: "23505"}, false},
+		{"pgx serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"pgx deadlock detected", &pgconn.PgError{Code: "40P01"}, true},
+		{"other pgx error", &pgconn.PgError{Code: "23505"}, false},
+		{"net error", &net.DNSError{IsTimeout: true}, true},
+		{"closed connection", net.ErrClosed, true},
+		{"plain error", errors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err); got != tt.want {
+				t.Errorf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	if got := fullJitter(0); got != 0 {
+		t.Errorf("fullJitter(0) = %v, want 0", got)
+	}
+
+	d := 100 * time.Millisecond
+	for i := 0; i < 50; i++ {
+		got := fullJitter(d)
+		if got < 0 || got > d {
+			t.Fatalf("fullJitter(%v) = %v, want in [0, %v]", d, got, d)
+		}
+	}
+}
+
+func TestWithRetry_RetriesTransientErrors(t *testing.T) {
+	orig := activeRetryPolicy
+	defer func() { activeRetryPolicy = orig }()
+	activeRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Multiplier: 2}
+
+	attempts := 0
+	err := withRetry(context.Background(), func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return context.DeadlineExceeded
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withRetry() error = %v, want nil", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestWithRetry_StopsOnNonTransientError(t *testing.T) {
+	orig := activeRetryPolicy
+	defer func() { activeRetryPolicy = orig }()
+	activeRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Multiplier: 2}
+
+	attempts := 0
+	wantErr := errors.New("not transient")
+	err := withRetry(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return wantErr
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("withRetry() error = %v, want %v", err, wantErr)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1 (no retry on non-transient error)", attempts)
+	}
+}
+
+func TestWithRetry_GivesUpAfterMaxAttempts(t *testing.T) {
+	orig := activeRetryPolicy
+	defer func() { activeRetryPolicy = orig }()
+	activeRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Multiplier: 2}
+
+	attempts := 0
+	err := withRetry(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return context.DeadlineExceeded
+	})
+	if err == nil {
+		t.Fatal("withRetry() error = nil, want non-nil after exhausting attempts")
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
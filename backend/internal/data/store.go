@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Rows is the subset of *sql.Rows used by this package, satisfied by *sql.Rows itself.
+type Rows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Close() error
+	Err() error
+}
+
+// Row is the subset of *sql.Row used by this package, satisfied by *sql.Row itself.
+type Row interface {
+	Scan(dest ...any) error
+}
+
+// Result is the subset of sql.Result used by this package, satisfied by sql.Result itself.
+type Result interface {
+	RowsAffected() (int64, error)
+}
+
+// Tx is the subset of *sql.Tx used by this package, satisfied by *sql.Tx itself.
+type Tx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) Row
+	Commit() error
+	Rollback() error
+}
+
+// Store abstracts the database handle this package runs queries against, so that tests can
+// inject a fake and so that database/sql and pgx-backed implementations (see NewSQLStore and
+// NewPgxStore) can be used interchangeably. Every package function that used to close over the
+// package-level *sql.DB now closes over a Store instead.
+type Store interface {
+	QueryContext(ctx context.Context, query string, args ...any) (Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) Row
+	ExecContext(ctx context.Context, query string, args ...any) (Result, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error)
+	// Notify publishes payload on channel via Postgres NOTIFY, e.g. for cross-instance token
+	// invalidation (see Token.DeleteToken, Token.DeleteAllForUser, Token.StartInvalidationListener).
+	Notify(ctx context.Context, channel, payload string) error
+}
+
+// store is the package-wide default Store, used by a User or Token value that wasn't built
+// through a Models (and so has no store of its own — see User.db/Token.db). New/NewWithStore and
+// Models.SetStore keep it pointed at the most recently constructed Models' Store.
+var store Store
+
+// sqlStore is the database/sql-backed Store implementation, used by default.
+type sqlStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore wraps an existing *sql.DB as a Store.
+func NewSQLStore(db *sql.DB) Store {
+	return &sqlStore{db: db}
+}
+
+func (s *sqlStore) QueryContext(ctx context.Context, query string, args ...any) (Rows, error) {
+	return s.db.QueryContext(ctx, query, args...)
+}
+
+func (s *sqlStore) QueryRowContext(ctx context.Context, query string, args ...any) Row {
+	return s.db.QueryRowContext(ctx, query, args...)
+}
+
+func (s *sqlStore) ExecContext(ctx context.Context, query string, args ...any) (Result, error) {
+	return s.db.ExecContext(ctx, query, args...)
+}
+
+func (s *sqlStore) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{tx: tx}, nil
+}
+
+// Notify publishes payload on channel using pg_notify, which works over any ordinary connection
+// (unlike LISTEN, which requires holding a dedicated connection open — see pgxStore.Listen).
+func (s *sqlStore) Notify(ctx context.Context, channel, payload string) error {
+	_, err := s.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// sqlTx adapts *sql.Tx to the Tx interface.
+type sqlTx struct {
+	tx *sql.Tx
+}
+
+func (t *sqlTx) ExecContext(ctx context.Context, query string, args ...any) (Result, error) {
+	return t.tx.ExecContext(ctx, query, args...)
+}
+
+func (t *sqlTx) QueryRowContext(ctx context.Context, query string, args ...any) Row {
+	return t.tx.QueryRowContext(ctx, query, args...)
+}
+
+func (t *sqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *sqlTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// ListenStore is implemented by Store backends that can hold a dedicated connection open to
+// receive Postgres NOTIFY messages, such as pgxStore. sqlStore does not implement it: the pooled
+// *sql.DB connections this package borrows per-call are unsuitable for a long-lived LISTEN.
+type ListenStore interface {
+	// Listen issues LISTEN channel on a dedicated connection and returns a channel of payloads
+	// delivered to it. The returned channel is closed when ctx is cancelled or the connection is
+	// lost.
+	Listen(ctx context.Context, channel string) (<-chan string, error)
+}
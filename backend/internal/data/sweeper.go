@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// defaultSweepLimit is the number of expired rows removed per DELETE issued by Sweep, and the
+// SweepOptions.SweepLimit used when none is configured.
+const defaultSweepLimit = 1000
+
+// defaultSweepInterval is the SweepOptions.SweepInterval used when none is configured: how often
+// StartSweeper runs a sweep burst under normal, non-backlogged conditions.
+const defaultSweepInterval = 30 * time.Minute
+
+// defaultSweepOverflowInterval is the SweepOptions.SweepOverflowInterval used when none is
+// configured: how soon StartSweeper re-runs a sweep after one hits SweepLimit, so a large backlog
+// of expired tokens drains in quick succession rather than waiting for the next SweepInterval.
+const defaultSweepOverflowInterval = time.Second
+
+// SweepOptions configures the background token sweeper started by Token.StartSweeper.
+type SweepOptions struct {
+	// SweepLimit caps how many expired rows a single Sweep call deletes. Defaults to
+	// defaultSweepLimit.
+	SweepLimit int
+	// SweepInterval is how often the sweeper runs under normal conditions. Defaults to
+	// defaultSweepInterval.
+	SweepInterval time.Duration
+	// SweepOverflowInterval is how soon the sweeper re-runs after a sweep deletes SweepLimit
+	// rows, indicating there may be more expired rows left to purge. Defaults to
+	// defaultSweepOverflowInterval.
+	SweepOverflowInterval time.Duration
+	// Logger receives sweep diagnostics. Defaults to log.Default().
+	Logger *log.Logger
+}
+
+// DefaultSweepOptions is the SweepOptions used by StartSweeper when none is supplied.
+var DefaultSweepOptions = SweepOptions{
+	SweepLimit:            defaultSweepLimit,
+	SweepInterval:         defaultSweepInterval,
+	SweepOverflowInterval: defaultSweepOverflowInterval,
+}
+
+// withDefaults returns a copy of opts with zero-valued fields filled in from DefaultSweepOptions.
+func (opts SweepOptions) withDefaults() SweepOptions {
+	if opts.SweepLimit <= 0 {
+		opts.SweepLimit = DefaultSweepOptions.SweepLimit
+	}
+	if opts.SweepInterval <= 0 {
+		opts.SweepInterval = DefaultSweepOptions.SweepInterval
+	}
+	if opts.SweepOverflowInterval <= 0 {
+		opts.SweepOverflowInterval = DefaultSweepOptions.SweepOverflowInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.Default()
+	}
+	return opts
+}
+
+// StartSweeper launches a goroutine that periodically deletes expired rows from tokens in
+// bounded batches. It ticks every opts.SweepInterval, and whenever a sweep deletes a full
+// opts.SweepLimit rows (signalling there may be more left to purge) it re-runs after the much
+// shorter opts.SweepOverflowInterval instead of waiting for the next tick, so a large backlog
+// drains quickly without a single giant DELETE. The goroutine exits when ctx is cancelled.
+func (t *Token) StartSweeper(ctx context.Context, opts SweepOptions) {
+	opts = opts.withDefaults()
+
+	go func() {
+		ticker := time.NewTicker(opts.SweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.sweepUntilDrained(ctx, opts)
+			}
+		}
+	}()
+}
+
+// sweepUntilDrained runs Sweep repeatedly, waiting opts.SweepOverflowInterval between runs, until
+// a sweep deletes fewer than opts.SweepLimit rows or ctx is cancelled.
+func (t *Token) sweepUntilDrained(ctx context.Context, opts SweepOptions) {
+	for {
+		deleted, err := t.sweep(ctx, opts.SweepLimit)
+		if err != nil {
+			opts.Logger.Printf("token sweep failed: %v", err)
+			return
+		}
+
+		if deleted < opts.SweepLimit {
+			return
+		}
+
+		opts.Logger.Printf("token sweep hit limit (%d), re-running in %s", opts.SweepLimit, opts.SweepOverflowInterval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(opts.SweepOverflowInterval):
+		}
+	}
+}
+
+// Sweep is the single-shot primitive behind StartSweeper: it deletes up to defaultSweepLimit
+// expired rows from tokens in one bounded batch. It is exposed directly so tests can exercise a
+// sweep without starting the background goroutine.
+//
+// Parameters:
+//   - ctx: controls cancellation and the retry deadline for the underlying DELETE.
+//
+// Returns:
+//   - The number of rows deleted.
+//   - An error if any occurs during the query execution.
+func (t *Token) Sweep(ctx context.Context) (deleted int, err error) {
+	return t.sweep(ctx, defaultSweepLimit)
+}
+
+// sweep deletes up to limit expired rows from tokens and reports how many were removed.
+func (t *Token) sweep(ctx context.Context, limit int) (int, error) {
+	query := "DELETE FROM tokens WHERE id IN (SELECT id FROM tokens WHERE expiry < $1 LIMIT $2)"
+
+	var affected int64
+	err := withRetry(ctx, func(ctx context.Context) error {
+		result, err := t.db().ExecContext(ctx, query, time.Now(), limit)
+		if err != nil {
+			return err
+		}
+		affected, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affected), nil
+}
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeStore is a minimal Store test double: each method delegates to the corresponding function
+// field, so a test only needs to set the ones its code path exercises. Calling an unset method
+// returns errUnimplemented rather than panicking on a nil function field.
+type fakeStore struct {
+	queryContext    func(ctx context.Context, query string, args ...any) (Rows, error)
+	queryRowContext func(ctx context.Context, query string, args ...any) Row
+	execContext     func(ctx context.Context, query string, args ...any) (Result, error)
+	beginTx         func(ctx context.Context, opts *sql.TxOptions) (Tx, error)
+	notify          func(ctx context.Context, channel, payload string) error
+}
+
+var errUnimplemented = errors.New("fakeStore: method not configured for this test")
+
+func (f *fakeStore) QueryContext(ctx context.Context, query string, args ...any) (Rows, error) {
+	if f.queryContext == nil {
+		return nil, errUnimplemented
+	}
+	return f.queryContext(ctx, query, args...)
+}
+
+func (f *fakeStore) QueryRowContext(ctx context.Context, query string, args ...any) Row {
+	if f.queryRowContext == nil {
+		return fakeRow{err: errUnimplemented}
+	}
+	return f.queryRowContext(ctx, query, args...)
+}
+
+func (f *fakeStore) ExecContext(ctx context.Context, query string, args ...any) (Result, error) {
+	if f.execContext == nil {
+		return nil, errUnimplemented
+	}
+	return f.execContext(ctx, query, args...)
+}
+
+func (f *fakeStore) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	if f.beginTx == nil {
+		return nil, errUnimplemented
+	}
+	return f.beginTx(ctx, opts)
+}
+
+func (f *fakeStore) Notify(ctx context.Context, channel, payload string) error {
+	if f.notify == nil {
+		return errUnimplemented
+	}
+	return f.notify(ctx, channel, payload)
+}
+
+// fakeRow is a Row that always returns err from Scan, for a queryRowContext that errors rather
+// than scanning (e.g. sql.ErrNoRows).
+type fakeRow struct {
+	err error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	return r.err
+}
+
+// fakeResult is a Result reporting a fixed RowsAffected.
+type fakeResult struct {
+	rowsAffected int64
+	err          error
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.rowsAffected, r.err
+}
+
+func TestToken_Sweep(t *testing.T) {
+	var gotQuery string
+	var gotLimit int
+	tok := &Token{store: &fakeStore{
+		execContext: func(ctx context.Context, query string, args ...any) (Result, error) {
+			gotQuery = query
+			gotLimit = args[1].(int)
+			return fakeResult{rowsAffected: 7}, nil
+		},
+	}}
+
+	deleted, err := tok.Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v, want nil", err)
+	}
+	if deleted != 7 {
+		t.Errorf("Sweep() deleted = %d, want 7", deleted)
+	}
+	if gotLimit != defaultSweepLimit {
+		t.Errorf("Sweep() issued limit = %d, want %d", gotLimit, defaultSweepLimit)
+	}
+	if gotQuery == "" {
+		t.Error("Sweep() did not issue a query")
+	}
+}
+
+func TestToken_Sweep_PropagatesError(t *testing.T) {
+	wantErr := errors.New("delete failed")
+	tok := &Token{store: &fakeStore{
+		execContext: func(ctx context.Context, query string, args ...any) (Result, error) {
+			return nil, wantErr
+		},
+	}}
+
+	orig := activeRetryPolicy
+	defer func() { activeRetryPolicy = orig }()
+	activeRetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+	_, err := tok.Sweep(context.Background())
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("Sweep() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestToken_SweepUntilDrained_ReRunsOnFullBatch(t *testing.T) {
+	calls := 0
+	tok := &Token{store: &fakeStore{
+		execContext: func(ctx context.Context, query string, args ...any) (Result, error) {
+			calls++
+			if calls < 3 {
+				return fakeResult{rowsAffected: 2}, nil
+			}
+			return fakeResult{rowsAffected: 0}, nil
+		},
+	}}
+
+	opts := SweepOptions{SweepLimit: 2, SweepOverflowInterval: time.Nanosecond}.withDefaults()
+	tok.sweepUntilDrained(context.Background(), opts)
+
+	if calls != 3 {
+		t.Errorf("sweepUntilDrained() made %d sweep calls, want 3 (two full batches then a short one)", calls)
+	}
+}
@@ -0,0 +1,410 @@
+package data
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// totpStepSeconds is the RFC 6238 time-step size used for all TOTP codes issued by this package.
+const totpStepSeconds = 30
+
+// totpDigits is the number of digits in a generated TOTP code.
+const totpDigits = 6
+
+// recoveryCodeCount is the number of single-use recovery codes generated when a user enrolls in TOTP.
+const recoveryCodeCount = 10
+
+// totpEncryptionKey is the AES-256 key used to encrypt TOTP secrets at rest. It must be set via
+// SetTOTPEncryptionKey before EnrollTOTP or VerifyTOTP are called.
+var totpEncryptionKey []byte
+
+// SetTOTPEncryptionKey configures the AES-256 key used to encrypt TOTP secrets before they are
+// persisted to the users table. The key must be exactly 32 bytes.
+func SetTOTPEncryptionKey(key []byte) error {
+	if len(key) != 32 {
+		return errors.New("totp encryption key must be 32 bytes")
+	}
+	totpEncryptionKey = key
+	return nil
+}
+
+// EnrollTOTP generates a new TOTP shared secret for the user, encrypts it at rest, and stores it
+// on the users row with totp_enabled left false until the caller verifies a code via ConfirmTOTP.
+// It returns the base32-encoded secret (for manual entry) and an otpauth:// URI suitable for
+// rendering as a QR code in the Vue frontend.
+//
+// If the user already has TOTP enabled, code must be a valid TOTP or recovery code for the
+// existing factor, the same proof of possession DisableTOTP requires — otherwise a stolen bearer
+// token could silently re-enroll TOTP and hand an attacker a fresh secret for an already-protected
+// account. code is ignored when the user has no TOTP enrolled yet.
+//
+// Parameters:
+//   - issuer: the issuer name shown in authenticator apps (e.g. "vue3-and-go").
+//   - code: a TOTP or recovery code for the existing factor, required only when TOTP is already
+//     enabled.
+//
+// Returns:
+//   - The base32-encoded shared secret.
+//   - An otpauth://totp/... URI encoding the secret, issuer, and account name.
+//   - An error if any occurs while generating, encrypting, or persisting the secret, or if the
+//     user already has TOTP enabled and code does not prove possession of it.
+func (u *User) EnrollTOTP(issuer, code string) (string, string, error) {
+	if u.TOTPEnabled {
+		valid, err := u.VerifyTOTP(code, 1)
+		if err != nil {
+			return "", "", err
+		}
+		if !valid {
+			valid, err = u.UseRecoveryCode(code)
+			if err != nil {
+				return "", "", err
+			}
+			if !valid {
+				return "", "", errors.New("invalid totp or recovery code")
+			}
+		}
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		log.Printf("failed to generate totp secret: %v", err)
+		return "", "", err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	encrypted, err := encryptTOTPSecret(secret)
+	if err != nil {
+		log.Printf("failed to encrypt totp secret: %v", err)
+		return "", "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := "UPDATE users SET totp_secret = $1, totp_enabled = false, updated_at = $2 WHERE id = $3"
+	err = withRetry(ctx, func(ctx context.Context) error {
+		_, err := u.db().ExecContext(ctx, query, encrypted, time.Now(), u.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to store totp secret: %v", err)
+		return "", "", err
+	}
+
+	u.TOTPSecret = encrypted
+	u.TOTPEnabled = false
+
+	otpauthURL := fmt.Sprintf(
+		"otpauth://totp/%s:%s?secret=%s&issuer=%s&algorithm=SHA1&digits=%d&period=%d",
+		url.QueryEscape(issuer), url.QueryEscape(u.Email), secret, url.QueryEscape(issuer), totpDigits, totpStepSeconds,
+	)
+
+	return secret, otpauthURL, nil
+}
+
+// ConfirmTOTP verifies a user-supplied TOTP code against the secret stored by EnrollTOTP and, if
+// valid, flips totp_enabled to true and issues a fresh batch of recovery codes, deleting any codes
+// left over from a previous enrollment first so an old secret's codes don't keep working forever.
+// The recovery codes are returned in plaintext exactly once; only their SHA-256 hashes are
+// persisted.
+//
+// Parameters:
+//   - code: the 6-digit TOTP code to verify.
+//
+// Returns:
+//   - The plaintext recovery codes generated for the user.
+//   - An error if the code is invalid or a database operation fails.
+func (u *User) ConfirmTOTP(code string) ([]string, error) {
+	valid, err := u.VerifyTOTP(code, 1)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, errors.New("invalid totp code")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := "UPDATE users SET totp_enabled = true, updated_at = $1 WHERE id = $2"
+	err = withRetry(ctx, func(ctx context.Context) error {
+		_, err := u.db().ExecContext(ctx, query, time.Now(), u.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to enable totp: %v", err)
+		return nil, err
+	}
+	u.TOTPEnabled = true
+
+	err = withRetry(ctx, func(ctx context.Context) error {
+		_, err := u.db().ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", u.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to delete recovery codes: %v", err)
+		return nil, err
+	}
+
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.storeRecoveryCodes(codes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP verifies a user-supplied TOTP code and, if valid, clears the stored secret and
+// removes all outstanding recovery codes for the user.
+//
+// Parameters:
+//   - code: the 6-digit TOTP code to verify before disabling.
+//
+// Returns:
+//   - An error if the code is invalid or a database operation fails.
+func (u *User) DisableTOTP(code string) error {
+	valid, err := u.VerifyTOTP(code, 1)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("invalid totp code")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := "UPDATE users SET totp_enabled = false, totp_secret = '', updated_at = $1 WHERE id = $2"
+	err = withRetry(ctx, func(ctx context.Context) error {
+		_, err := u.db().ExecContext(ctx, query, time.Now(), u.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to disable totp: %v", err)
+		return err
+	}
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+
+	err = withRetry(ctx, func(ctx context.Context) error {
+		_, err := u.db().ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", u.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to delete recovery codes: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// VerifyTOTP implements RFC 6238 TOTP verification (HMAC-SHA1, 30s step, 6 digits) against the
+// user's stored secret, accepting codes from skew time steps before or after the current one to
+// absorb clock drift between client and server.
+//
+// Parameters:
+//   - code: the 6-digit TOTP code to verify.
+//   - skew: the number of time steps of drift to tolerate on either side of now.
+//
+// Returns:
+//   - True if code matches any time step within the allowed skew, false otherwise.
+//   - An error if the user has no TOTP secret enrolled or the secret cannot be decoded.
+func (u *User) VerifyTOTP(code string, skew int) (bool, error) {
+	if u.TOTPSecret == "" {
+		return false, errors.New("totp not enrolled for user")
+	}
+
+	secret, err := decryptTOTPSecret(u.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return false, err
+	}
+
+	counter := time.Now().Unix() / totpStepSeconds
+	for delta := -skew; delta <= skew; delta++ {
+		candidate, err := hotp(key, uint64(counter+int64(delta)))
+		if err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(code)) == 1 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// hotp computes an RFC 4226 HOTP code for the given key and counter value, truncated to
+// totpDigits digits. TOTP is HOTP with the counter derived from the current time.
+func hotp(key []byte, counter uint64) (string, error) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, counter)
+
+	mac := hmac.New(sha1.New, key)
+	if _, err := mac.Write(buf); err != nil {
+		return "", err
+	}
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := (uint32(sum[offset]&0x7f) << 24) |
+		(uint32(sum[offset+1]) << 16) |
+		(uint32(sum[offset+2]) << 8) |
+		uint32(sum[offset+3])
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, truncated%mod), nil
+}
+
+// encryptTOTPSecret encrypts a base32-encoded TOTP secret with AES-256-GCM using
+// totpEncryptionKey and returns the base64-encoded nonce+ciphertext.
+func encryptTOTPSecret(secret string) (string, error) {
+	if len(totpEncryptionKey) != 32 {
+		return "", errors.New("totp encryption key is not configured")
+	}
+
+	block, err := aes.NewCipher(totpEncryptionKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	ciphertext := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// decryptTOTPSecret reverses encryptTOTPSecret, returning the base32-encoded TOTP secret.
+func decryptTOTPSecret(encoded string) (string, error) {
+	if len(totpEncryptionKey) != 32 {
+		return "", errors.New("totp encryption key is not configured")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(totpEncryptionKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(raw) < nonceSize {
+		return "", errors.New("malformed totp secret ciphertext")
+	}
+	nonce, ciphertext := raw[:nonceSize], raw[nonceSize:]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// generateRecoveryCodes returns n random 32-character base32 recovery codes.
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		raw := make([]byte, 20)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		codes[i] = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+	}
+	return codes, nil
+}
+
+// storeRecoveryCodes persists the SHA-256 hash of each recovery code for the user. Plaintext
+// codes are never written to the database.
+func (u *User) storeRecoveryCodes(codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := "INSERT INTO user_recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)"
+	for _, code := range codes {
+		hash := sha256.Sum256([]byte(code))
+		err := withRetry(ctx, func(ctx context.Context) error {
+			_, err := u.db().ExecContext(ctx, query, u.ID, hash[:], time.Now())
+			return err
+		})
+		if err != nil {
+			log.Printf("failed to store recovery code: %v", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode consumes a single-use recovery code as a fallback when a user cannot produce a
+// current TOTP code (e.g. a lost device). It returns true and deletes the code if it matched an
+// unused code for this user, or false if the code was unknown or already used.
+//
+// Parameters:
+//   - code: the plaintext recovery code supplied by the user.
+//
+// Returns:
+//   - True if the code was valid and has now been consumed, false otherwise.
+//   - An error if the database operation fails.
+func (u *User) UseRecoveryCode(code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(code))
+	query := "DELETE FROM user_recovery_codes WHERE user_id = $1 AND code_hash = $2"
+
+	var affected int64
+	err := withRetry(ctx, func(ctx context.Context) error {
+		result, err := u.db().ExecContext(ctx, query, u.ID, hash[:])
+		if err != nil {
+			return err
+		}
+		affected, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to consume recovery code: %v", err)
+		return false, err
+	}
+
+	return affected > 0, nil
+}